@@ -0,0 +1,53 @@
+package download
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ImportEntry is a single resource address/ID pair destined for an
+// imports.tf block and the matching `terraform import` script line.
+type ImportEntry struct {
+	Address string
+	ID      string
+}
+
+// ImportWriter accumulates the ImportEntry values produced while writing
+// a single resource folder and flushes them, when dlConfig.EmitImports is
+// set, as a sibling imports.tf (using Terraform 1.5+ import blocks) plus
+// an import.sh script of equivalent `terraform import` commands for
+// older CLI versions.
+type ImportWriter struct {
+	entries []ImportEntry
+}
+
+// Add records an import for the given resource type, local name and ID.
+// The local name must be the one written into the HCL block (the escaped
+// name produced by NameCounter.Numbering when dlConfig.CommentedID is
+// set) so the import block's address matches the HCL.
+func (writer *ImportWriter) Add(resType, localName, id string) {
+	writer.entries = append(writer.entries, ImportEntry{Address: resType + "." + localName, ID: id})
+}
+
+// Flush writes imports.tf and import.sh into folder. It is a no-op when
+// no imports were recorded.
+func (writer *ImportWriter) Flush(folder string) error {
+	if len(writer.entries) == 0 {
+		return nil
+	}
+
+	var tf strings.Builder
+	var sh strings.Builder
+	sh.WriteString("#!/usr/bin/env bash\nset -euo pipefail\n\n")
+	for _, entry := range writer.entries {
+		fmt.Fprintf(&tf, "import {\n  to = %s\n  id = %q\n}\n\n", entry.Address, entry.ID)
+		fmt.Fprintf(&sh, "terraform import %s %q\n", entry.Address, entry.ID)
+	}
+
+	if err := os.WriteFile(filepath.Join(folder, "imports.tf"), []byte(tf.String()), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(folder, "import.sh"), []byte(sh.String()), 0755)
+}
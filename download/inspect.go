@@ -0,0 +1,259 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/dtcookie/hcl"
+	"github.com/dynatrace-oss/terraform-provider-dynatrace/hclgen"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+)
+
+// DeclaredResource represents a resource block already present in a
+// previously generated .tf file, as discovered by ParseExistingConfig.
+type DeclaredResource struct {
+	Type      string
+	LocalName string
+	ID        string
+	Hash      string
+	File      string
+}
+
+// ConfigIndex indexes the resources already declared under
+// dlConfig.TargetFolder. It is built once per download run and lets
+// WriteResourceSeparate skip or selectively refresh resources instead of
+// always overwriting the full tenant.
+type ConfigIndex struct {
+	byTypeAndID map[string]*DeclaredResource
+}
+
+var idCommentPattern = regexp.MustCompile(`(?m)^\s*#\s*id\s*=\s*"?([^"\s]+)"?\s*$`)
+var hashCommentPattern = regexp.MustCompile(`(?m)^\s*#\s*hash\s*=\s*"?([0-9a-f]+)"?\s*$`)
+var topLevelAttrPattern = regexp.MustCompile(`(?m)^\s*([a-zA-Z0-9_]+)\s*=\s*(.+)$`)
+
+// readCommentedIDFromText is the string-based counterpart of the
+// file-reading lookup in ParseExistingConfig, used by RecordSink which
+// already holds the rendered HCL in memory.
+func readCommentedIDFromText(rendered string) (string, bool) {
+	match := idCommentPattern.FindStringSubmatch(rendered)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// parseTopLevelAttributes extracts simple `key = value` lines from
+// rendered HCL. It does not descend into nested blocks, so it is only a
+// best-effort summary suitable for RecordSink's "attributes" field.
+func parseTopLevelAttributes(rendered string) map[string]string {
+	var attrs map[string]string
+	depth := 0
+	for _, line := range strings.Split(rendered, "\n") {
+		// Depth 1 is the body of the resource block itself.
+		if depth == 1 {
+			if match := topLevelAttrPattern.FindStringSubmatch(line); match != nil {
+				if attrs == nil {
+					attrs = map[string]string{}
+				}
+				attrs[match[1]] = strings.TrimSpace(match[2])
+			}
+		}
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+	}
+	return attrs
+}
+
+// ParseExistingConfig walks targetFolder for .tf files using an
+// HCL-inspection walker analogous to hashicorp/terraform-config-inspect
+// and builds an index of already-declared resources. When commentedID is
+// true, the `id = ...` (and, if present, `hash = ...`) comments emitted by
+// WriteResourceSeparate are parsed back out so resources can be matched
+// by their Dynatrace object ID. Only resources whose file carries an id
+// comment are indexed; when commentedID is false the index is empty.
+//
+// tfconfig.LoadModule only inspects the .tf files directly inside the
+// directory it's given - it doesn't recurse - but WriteResourceSeparate
+// and FileSink write each resource type into its own subfolder under
+// targetFolder. So every directory at or below targetFolder that contains
+// .tf files is loaded as its own module and the results are merged,
+// instead of loading targetFolder alone.
+func ParseExistingConfig(targetFolder string, commentedID bool) (*ConfigIndex, error) {
+	idx := &ConfigIndex{byTypeAndID: map[string]*DeclaredResource{}}
+	if !commentedID {
+		return idx, nil
+	}
+
+	moduleDirs, err := tfModuleDirs(targetFolder)
+	if err != nil {
+		return idx, err
+	}
+
+	for _, dir := range moduleDirs {
+		mod, diags := tfconfig.LoadModule(dir)
+		if diags.HasErrors() {
+			return idx, diags.Err()
+		}
+
+		for _, res := range mod.ManagedResources {
+			content, err := os.ReadFile(res.Pos.Filename)
+			if err != nil {
+				continue
+			}
+			idMatch := idCommentPattern.FindSubmatch(content)
+			if idMatch == nil {
+				continue
+			}
+			decl := &DeclaredResource{
+				Type:      res.Type,
+				LocalName: res.Name,
+				ID:        string(idMatch[1]),
+				File:      res.Pos.Filename,
+			}
+			if hashMatch := hashCommentPattern.FindSubmatch(content); hashMatch != nil {
+				decl.Hash = string(hashMatch[1])
+			}
+			idx.byTypeAndID[res.Type+"/"+decl.ID] = decl
+		}
+	}
+
+	return idx, nil
+}
+
+// tfModuleDirs returns every directory at or below root that directly
+// contains at least one ".tf" file, so ParseExistingConfig can load each
+// resource-type subfolder as its own module.
+func tfModuleDirs(root string) ([]string, error) {
+	seen := map[string]bool{}
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".tf") {
+			return nil
+		}
+		if dir := filepath.Dir(path); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return dirs, err
+}
+
+// HasID reports whether resType/id is already declared in the index.
+// Used to implement dlConfig.OnlyMissing.
+func (idx *ConfigIndex) HasID(resType, id string) bool {
+	if idx == nil {
+		return false
+	}
+	_, found := idx.byTypeAndID[resType+"/"+id]
+	return found
+}
+
+// UpToDate reports whether resType/id is declared in the index with a
+// hash matching the given one. Used to implement dlConfig.RefreshExisting.
+func (idx *ConfigIndex) UpToDate(resType, id, hash string) bool {
+	if idx == nil {
+		return false
+	}
+	decl, found := idx.byTypeAndID[resType+"/"+id]
+	return found && decl.Hash != "" && decl.Hash == hash
+}
+
+// HashRESTObject renders obj the same way hclgen.Export would and
+// returns a hex-encoded sha256 digest of the result, so callers can tell
+// whether the upstream object changed since the last download.
+func HashRESTObject(obj hcl.Marshaler, resName string, localName string) (string, error) {
+	var buf bytes.Buffer
+	if err := hclgen.Export(obj, &buf, resName, localName); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// DriftEntry describes a single resource present on only one side of a
+// drift comparison.
+type DriftEntry struct {
+	Type string `json:"resource_type"`
+	Name string `json:"name"`
+	ID   string `json:"id,omitempty"`
+}
+
+// DriftReport is the JSON document produced for dlConfig.ReportDrift: the
+// resources that exist in Dynatrace but are missing locally, and vice
+// versa.
+type DriftReport struct {
+	MissingLocally  []DriftEntry `json:"missing_locally"`
+	MissingRemotely []DriftEntry `json:"missing_remotely"`
+}
+
+// ComputeDrift compares resources fetched from Dynatrace against idx and
+// reports resources present on only one side.
+func ComputeDrift(idx *ConfigIndex, resources Resources, resName string) DriftReport {
+	var report DriftReport
+	seenRemotely := map[string]bool{}
+
+	for _, resource := range resources {
+		seenRemotely[resource.ID] = true
+		if !idx.HasID(resName, resource.ID) {
+			report.MissingLocally = append(report.MissingLocally, DriftEntry{Type: resName, Name: resource.Name, ID: resource.ID})
+		}
+	}
+	for key, decl := range idx.byTypeAndID {
+		if !strings.HasPrefix(key, resName+"/") || seenRemotely[decl.ID] {
+			continue
+		}
+		report.MissingRemotely = append(report.MissingRemotely, DriftEntry{Type: resName, Name: decl.LocalName, ID: decl.ID})
+	}
+
+	return report
+}
+
+// WriteDriftReport serializes report as JSON into a ".drift.json" file
+// directly under targetFolder.
+func WriteDriftReport(targetFolder string, report DriftReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(targetFolder, ".drift.json"), data, os.ModePerm)
+}
+
+// DriftAccumulator collects the DriftReport produced for each resource
+// type into a single report, so a multi-type download can call Flush
+// once at the end instead of every type's WriteResourceSeparate call
+// overwriting the previous type's ".drift.json".
+type DriftAccumulator struct {
+	mu     sync.Mutex
+	report DriftReport
+}
+
+// Add merges report's entries into the accumulator.
+func (acc *DriftAccumulator) Add(report DriftReport) {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	acc.report.MissingLocally = append(acc.report.MissingLocally, report.MissingLocally...)
+	acc.report.MissingRemotely = append(acc.report.MissingRemotely, report.MissingRemotely...)
+}
+
+// Flush writes the accumulated report to targetFolder.
+func (acc *DriftAccumulator) Flush(targetFolder string) error {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	return WriteDriftReport(targetFolder, acc.report)
+}
@@ -0,0 +1,223 @@
+package download
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ProgressEvent is emitted on a Pipeline's progress channel as resources
+// are processed, so callers can render a progress bar without polling.
+type ProgressEvent struct {
+	ResourceType string
+	Done         int
+	Total        int
+	Err          error
+}
+
+// MultiError aggregates the errors produced by a Pipeline run when
+// FailFast is false, so a handful of bad resources don't abort an
+// otherwise successful download of a large tenant.
+type MultiError []error
+
+func (errs MultiError) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	msg := errs[0].Error()
+	for _, err := range errs[1:] {
+		msg += "; " + err.Error()
+	}
+	return msg
+}
+
+// RateLimiter is a token-bucket limiter guarding calls against the
+// Dynatrace API. Wait blocks until a token is available.
+type RateLimiter struct {
+	tokens   chan struct{}
+	ticker   *time.Ticker
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewRateLimiter creates a limiter that admits at most ratePerSecond
+// calls per second, bursting up to ratePerSecond.
+func NewRateLimiter(ratePerSecond int) *RateLimiter {
+	if ratePerSecond <= 0 {
+		ratePerSecond = 1
+	}
+	limiter := &RateLimiter{
+		tokens: make(chan struct{}, ratePerSecond),
+		ticker: time.NewTicker(time.Second / time.Duration(ratePerSecond)),
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < ratePerSecond; i++ {
+		limiter.tokens <- struct{}{}
+	}
+	go limiter.refill()
+	return limiter
+}
+
+func (limiter *RateLimiter) refill() {
+	for {
+		select {
+		case <-limiter.ticker.C:
+			select {
+			case limiter.tokens <- struct{}{}:
+			default:
+			}
+		case <-limiter.done:
+			return
+		}
+	}
+}
+
+// Wait blocks until a token is available.
+func (limiter *RateLimiter) Wait() {
+	<-limiter.tokens
+}
+
+// Stop releases the limiter's background ticker.
+func (limiter *RateLimiter) Stop() {
+	limiter.stopOnce.Do(func() {
+		limiter.ticker.Stop()
+		close(limiter.done)
+	})
+}
+
+// ResourceFolder pairs a resource type's download folder with the
+// resources discovered for it, the unit of work a Pipeline fans out.
+type ResourceFolder struct {
+	Folder    string
+	Resources Resources
+}
+
+// Pipeline fans a download out across a worker pool, the parallel
+// counterpart of calling WriteResourceSeparate once per resource type in
+// a loop. Workers share a single NameCounter behind dlConfig.NameLock,
+// since local names must stay unique across the whole download even
+// when resource types are exported concurrently.
+type Pipeline struct {
+	// Parallelism is the number of workers; it defaults to
+	// dlConfig.Parallelism, or runtime.NumCPU when that is zero.
+	Parallelism int
+	// RateLimiter, if set, is consulted before each resource type is
+	// exported.
+	RateLimiter *RateLimiter
+	// FailFast stops the pipeline on the first error instead of
+	// aggregating every error into a MultiError.
+	FailFast bool
+	// Progress, if set, receives a ProgressEvent after each resource type
+	// is processed. The caller must drain it concurrently with Run (or
+	// provide enough buffer for len(resources) events) - Run blocks on
+	// wg.Wait() until every worker has finished, so a worker stuck
+	// sending to an undrained, unbuffered Progress would deadlock the
+	// whole pipeline.
+	Progress chan<- ProgressEvent
+}
+
+// job is one unit of work dispatched to the worker pool: writing all
+// resources of a single type.
+type job struct {
+	resName   string
+	resFolder string
+	resources Resources
+}
+
+// Run writes every resource type in resources through
+// WriteResourceSeparate, fanning the work out across Parallelism
+// workers.
+func (pipeline *Pipeline) Run(me ResourceData, dlConfig DownloadConfig, resources map[string]ResourceFolder, resNameCnt NameCounter) error {
+	parallelism := pipeline.Parallelism
+	if parallelism <= 0 {
+		parallelism = dlConfig.Parallelism
+	}
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+
+	if dlConfig.NameLock == nil {
+		dlConfig.NameLock = &sync.Mutex{}
+	}
+
+	// Drift reports are computed per resource type but must land in one
+	// .drift.json covering the whole download: own the accumulator here
+	// and flush it once, after every worker has finished, instead of
+	// letting concurrent workers race to overwrite the same file.
+	if dlConfig.ReportDrift && dlConfig.DriftAccumulator == nil {
+		dlConfig.DriftAccumulator = &DriftAccumulator{}
+	}
+
+	jobs := make(chan job, len(resources))
+	for resName, folder := range resources {
+		jobs <- job{resName: resName, resFolder: folder.Folder, resources: folder.Resources}
+	}
+	close(jobs)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		errs     MultiError
+		done     int
+		total    = len(resources)
+		firstErr error
+	)
+
+	worker := func() {
+		defer wg.Done()
+		for task := range jobs {
+			if pipeline.RateLimiter != nil {
+				pipeline.RateLimiter.Wait()
+			}
+
+			err := me.WriteResourceSeparate(dlConfig, task.resName, task.resFolder, task.resources, resNameCnt)
+
+			mu.Lock()
+			done++
+			doneSnapshot := done
+			if err != nil {
+				errs = append(errs, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+			stopNow := pipeline.FailFast && firstErr != nil
+			mu.Unlock()
+
+			// Send outside the lock: Progress may be slow or unbuffered,
+			// and every other worker needs mu to record its own result.
+			if pipeline.Progress != nil {
+				pipeline.Progress <- ProgressEvent{ResourceType: task.resName, Done: doneSnapshot, Total: total, Err: err}
+			}
+
+			if stopNow {
+				return
+			}
+		}
+	}
+
+	wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go worker()
+	}
+	wg.Wait()
+
+	if dlConfig.DriftAccumulator != nil {
+		if err := dlConfig.DriftAccumulator.Flush(dlConfig.TargetFolder); err != nil {
+			return err
+		}
+	}
+	if dlConfig.Sink != nil {
+		if err := dlConfig.Sink.Close(); err != nil {
+			return err
+		}
+	}
+
+	if pipeline.FailFast {
+		return firstErr
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
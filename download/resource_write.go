@@ -1,9 +1,9 @@
 package download
 
 import (
-	"errors"
-	"os"
-	"strings"
+	"fmt"
+	"io"
+	"path/filepath"
 
 	"github.com/dtcookie/hcl"
 	"github.com/dynatrace-oss/terraform-provider-dynatrace/hclgen"
@@ -11,65 +11,163 @@ import (
 
 func (me ResourceData) WriteResourceSeparate(dlConfig DownloadConfig, resName string, resFolder string, resources Resources, resNameCnt NameCounter) error {
 	var err error
+
+	if (dlConfig.OnlyMissing || dlConfig.RefreshExisting || dlConfig.ReportDrift) && !dlConfig.CommentedID {
+		return fmt.Errorf("dlConfig.OnlyMissing, dlConfig.RefreshExisting and dlConfig.ReportDrift all require dlConfig.CommentedID, since they match resources by the id comment embedded in previously downloaded files")
+	}
+
+	var idx *ConfigIndex
+	if dlConfig.OnlyMissing || dlConfig.RefreshExisting || dlConfig.ReportDrift {
+		if idx, err = ParseExistingConfig(dlConfig.TargetFolder, dlConfig.CommentedID); err != nil {
+			return err
+		}
+	}
+	if dlConfig.ReportDrift {
+		drift := ComputeDrift(idx, resources, resName)
+		if dlConfig.DriftAccumulator != nil {
+			// Let the caller flush once every resource type has been
+			// processed, instead of clobbering .drift.json per type.
+			dlConfig.DriftAccumulator.Add(drift)
+		} else if err := WriteDriftReport(dlConfig.TargetFolder, drift); err != nil {
+			return err
+		}
+	}
+
+	// A sink passed in via dlConfig.Sink is caller-owned: it may be shared
+	// across several calls to WriteResourceSeparate (one per resource
+	// type), so only close sinks this call creates itself.
+	sink := dlConfig.Sink
+	ownsSink := sink == nil
+	if ownsSink {
+		sink = NewFileSink(dlConfig.TargetFolder, resFolder)
+	}
+
+	var importWriter ImportWriter
+
 	for _, resource := range resources {
 		if resource.ReqInter {
 			continue
 		}
 
-		var file *os.File
-		fileName := dlConfig.TargetFolder + "/" + resFolder + "/" + resFolder + "." + escf(resource.Name) + ".tf"
-		os.Remove(fileName)
-		if file, err = os.Create(fileName); err != nil {
+		// exportName is the local name actually written into the HCL
+		// block. Numbering is only applied when CommentedID is set, to
+		// match the plain (non-commented) layout's historical naming.
+		var exportName string
+		if dlConfig.CommentedID {
+			if dlConfig.NameLock != nil {
+				dlConfig.NameLock.Lock()
+				exportName = resNameCnt.Numbering(escape(resource.Name))
+				dlConfig.NameLock.Unlock()
+			} else {
+				exportName = resNameCnt.Numbering(escape(resource.Name))
+			}
+		} else {
+			exportName = escape(resource.Name)
+		}
+
+		if dlConfig.OnlyMissing && idx.HasID(resName, resource.ID) {
+			continue
+		}
+
+		var hash string
+		if dlConfig.RefreshExisting {
+			if hash, err = HashRESTObject(resource.RESTObject, resName, exportName); err != nil {
+				return err
+			}
+			if idx.UpToDate(resName, resource.ID, hash) {
+				continue
+			}
+		}
+
+		var file io.WriteCloser
+		if file, err = sink.OpenResource(resName, resource.Name); err != nil {
 			return err
 		}
 
 		if dlConfig.CommentedID {
-			if err := hclgen.Export(resource.RESTObject, file, resName, resNameCnt.Numbering(escape(resource.Name)), "id = "+resource.ID); err != nil {
+			extraLines := []string{"id = " + resource.ID}
+			if dlConfig.RefreshExisting {
+				extraLines = append(extraLines, "hash = "+hash)
+			}
+			if err := hclgen.Export(resource.RESTObject, file, resName, exportName, extraLines...); err != nil {
 				file.Close()
 				return err
 			}
 		} else {
-			if err := hclgen.Export(resource.RESTObject, file, resName, escape(resource.Name)); err != nil {
+			if err := hclgen.Export(resource.RESTObject, file, resName, exportName); err != nil {
 				file.Close()
 				return err
 			}
 		}
 
+		if dlConfig.EmitImports {
+			importWriter.Add(resName, exportName, resource.ID)
+		}
+
 		if resName == "dynatrace_dashboard" {
-			if err := me.writeDashboardSharing(file, resource.Name); err != nil {
+			sharingID, err := me.writeDashboardSharing(file, resource.Name)
+			if err != nil {
 				file.Close()
 				return err
 			}
+			if dlConfig.EmitImports && sharingID != "" {
+				importWriter.Add("dynatrace_dashboard_sharing", escape(resource.Name), sharingID)
+			}
 		}
+
 		file.Close()
 	}
 
+	if dlConfig.EmitImports {
+		if err := importWriter.Flush(filepath.Join(dlConfig.TargetFolder, resFolder)); err != nil {
+			return err
+		}
+	}
+
+	if ownsSink {
+		return sink.Close()
+	}
 	return nil
 }
 
-func (me ResourceData) writeDashboardSharing(file *os.File, name string) error {
+// writeDashboardSharing writes the paired dynatrace_dashboard_sharing
+// resource into file, if one was downloaded for this dashboard. It
+// returns that resource's ID (or "" if none was found) so callers can
+// also register it for dlConfig.EmitImports. The caller owns file's
+// lifecycle in every case - writeDashboardSharing never closes it - since
+// with the buffered sinks (SingleFileSink/ArchiveSink/RecordSink) Close
+// is the commit point that flushes the entry into the shared
+// file/archive/record, and closing here would flush it twice.
+func (me ResourceData) writeDashboardSharing(file io.WriteCloser, name string) (string, error) {
 	var restObject hcl.Marshaler
+	var sharingID string
 	var found bool
 	for _, resource := range me["dynatrace_dashboard_sharing"] {
 		if resource.Name == name {
 			restObject = resource.RESTObject
+			sharingID = resource.ID
 			found = true
 			break
 		}
 	}
 	if !found {
-		file.Close()
-		return nil
+		return "", nil
 	}
 	if err := hclgen.Export(restObject, file, "dynatrace_dashboard_sharing", escape(name)); err != nil {
-		file.Close()
-		return err
+		return "", err
 	}
-	return nil
+	return sharingID, nil
 }
 
 func (me ResourceData) WriteResReqAttn(dlConfig DownloadConfig) error {
 	var err error
+
+	sink := dlConfig.Sink
+	ownsSink := sink == nil
+	if ownsSink {
+		sink = NewReqAttnSink(dlConfig.TargetFolder)
+	}
+
 	for resName := range InterventionInfoMap {
 		if resources, exists := me[resName]; exists {
 			for _, resource := range resources {
@@ -77,18 +175,8 @@ func (me ResourceData) WriteResReqAttn(dlConfig DownloadConfig) error {
 					continue
 				}
 
-				folderName := dlConfig.TargetFolder + "/" + ".requires_attention"
-				if _, err := os.Stat(folderName); errors.Is(err, os.ErrNotExist) {
-					err := os.Mkdir(folderName, os.ModePerm)
-					if err != nil {
-						return err
-					}
-				}
-
-				var file *os.File
-				fileName := folderName + "/" + strings.TrimPrefix(resName, "dynatrace_") + "." + escf(resource.Name) + ".tf"
-				os.Remove(fileName)
-				if file, err = os.Create(fileName); err != nil {
+				var file io.WriteCloser
+				if file, err = sink.OpenResource(resName, resource.Name); err != nil {
 					return err
 				}
 
@@ -108,5 +196,9 @@ func (me ResourceData) WriteResReqAttn(dlConfig DownloadConfig) error {
 			}
 		}
 	}
+
+	if ownsSink {
+		return sink.Close()
+	}
 	return nil
 }
@@ -0,0 +1,317 @@
+package download
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OutputSink abstracts where WriteResourceSeparate and WriteResReqAttn
+// write rendered HCL, so the downloader can be driven as a library and
+// tested without a temp directory, and so callers can target layouts
+// other than one `.tf` file per resource (a single file per type, a
+// tar/zip archive, or a JSON/YAML record stream for downstream tooling).
+type OutputSink interface {
+	// OpenResource returns a writer for resType/name. The caller writes
+	// the rendered HCL into it and closes it once done.
+	OpenResource(resType, name string) (io.WriteCloser, error)
+	// Close finalizes the sink, flushing any archive or record stream.
+	Close() error
+}
+
+// FileSink is the default OutputSink: one `.tf` file per resource, laid
+// out exactly as WriteResourceSeparate has always produced them.
+type FileSink struct {
+	TargetFolder string
+	ResFolder    string
+}
+
+// NewFileSink creates the sink backing the current per-file layout.
+func NewFileSink(targetFolder, resFolder string) *FileSink {
+	return &FileSink{TargetFolder: targetFolder, ResFolder: resFolder}
+}
+
+func (sink *FileSink) OpenResource(resType, name string) (io.WriteCloser, error) {
+	fileName := filepath.Join(sink.TargetFolder, sink.ResFolder, sink.ResFolder+"."+escf(name)+".tf")
+	os.Remove(fileName)
+	return os.Create(fileName)
+}
+
+func (sink *FileSink) Close() error { return nil }
+
+// ReqAttnSink lays out resources needing manual review under a single
+// ".requires_attention" folder, named by resource type rather than by a
+// shared folder prefix. It mirrors the layout WriteResReqAttn has always
+// produced.
+type ReqAttnSink struct {
+	TargetFolder string
+}
+
+// NewReqAttnSink creates the sink backing WriteResReqAttn's default
+// layout.
+func NewReqAttnSink(targetFolder string) *ReqAttnSink {
+	return &ReqAttnSink{TargetFolder: targetFolder}
+}
+
+func (sink *ReqAttnSink) OpenResource(resType, name string) (io.WriteCloser, error) {
+	folderName := filepath.Join(sink.TargetFolder, ".requires_attention")
+	if _, err := os.Stat(folderName); errors.Is(err, os.ErrNotExist) {
+		if err := os.Mkdir(folderName, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	fileName := filepath.Join(folderName, strings.TrimPrefix(resType, "dynatrace_")+"."+escf(name)+".tf")
+	os.Remove(fileName)
+	return os.Create(fileName)
+}
+
+func (sink *ReqAttnSink) Close() error { return nil }
+
+// SingleFileSink collects every resource of a type into one `.tf` file
+// instead of one file per resource. OpenResource hands callers a private
+// buffer rather than the shared file directly, so concurrent writers
+// (e.g. Pipeline workers exporting different resource types at once)
+// can't interleave their writes - each entry's bytes only reach the
+// shared file, under sink.mu, once that entry is Closed.
+type SingleFileSink struct {
+	TargetFolder string
+	ResFolder    string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewSingleFileSink creates a sink that appends every OpenResource call
+// into a single shared file.
+func NewSingleFileSink(targetFolder, resFolder string) *SingleFileSink {
+	return &SingleFileSink{TargetFolder: targetFolder, ResFolder: resFolder}
+}
+
+func (sink *SingleFileSink) OpenResource(resType, name string) (io.WriteCloser, error) {
+	return &singleFileEntry{sink: sink}, nil
+}
+
+func (sink *SingleFileSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.file == nil {
+		return nil
+	}
+	return sink.file.Close()
+}
+
+// singleFileEntry buffers one resource's rendered HCL so it can be
+// appended to the shared file as a single, uninterrupted write.
+type singleFileEntry struct {
+	sink *SingleFileSink
+	buf  bytes.Buffer
+}
+
+func (entry *singleFileEntry) Write(p []byte) (int, error) { return entry.buf.Write(p) }
+
+func (entry *singleFileEntry) Close() error {
+	entry.sink.mu.Lock()
+	defer entry.sink.mu.Unlock()
+
+	if entry.sink.file == nil {
+		fileName := filepath.Join(entry.sink.TargetFolder, entry.sink.ResFolder+".tf")
+		os.Remove(fileName)
+		file, err := os.Create(fileName)
+		if err != nil {
+			return err
+		}
+		entry.sink.file = file
+	}
+	_, err := entry.sink.file.Write(entry.buf.Bytes())
+	return err
+}
+
+// ArchiveFormat selects the container format used by an ArchiveSink.
+type ArchiveFormat int
+
+const (
+	ArchiveFormatTar ArchiveFormat = iota
+	ArchiveFormatZip
+)
+
+// ArchiveSink writes every resource as an entry of a tar or zip archive
+// instead of a loose file, so a download can be shipped as a single
+// artifact.
+type ArchiveSink struct {
+	Format ArchiveFormat
+
+	mu        sync.Mutex
+	archive   *os.File
+	tarWriter *tar.Writer
+	zipWriter *zip.Writer
+}
+
+// NewArchiveSink creates an archive at archivePath in the given format.
+func NewArchiveSink(archivePath string, format ArchiveFormat) (*ArchiveSink, error) {
+	os.Remove(archivePath)
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	sink := &ArchiveSink{Format: format, archive: file}
+	switch format {
+	case ArchiveFormatZip:
+		sink.zipWriter = zip.NewWriter(file)
+	default:
+		sink.tarWriter = tar.NewWriter(file)
+	}
+	return sink, nil
+}
+
+func (sink *ArchiveSink) OpenResource(resType, name string) (io.WriteCloser, error) {
+	return &archiveEntry{sink: sink, name: resType + "." + escf(name) + ".tf"}, nil
+}
+
+func (sink *ArchiveSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.tarWriter != nil {
+		if err := sink.tarWriter.Close(); err != nil {
+			return err
+		}
+	}
+	if sink.zipWriter != nil {
+		if err := sink.zipWriter.Close(); err != nil {
+			return err
+		}
+	}
+	return sink.archive.Close()
+}
+
+// archiveEntry buffers a resource's rendered HCL so it can be written as
+// a single tar/zip entry with a known size once complete.
+type archiveEntry struct {
+	sink *ArchiveSink
+	name string
+	buf  bytes.Buffer
+}
+
+func (entry *archiveEntry) Write(p []byte) (int, error) { return entry.buf.Write(p) }
+
+func (entry *archiveEntry) Close() error {
+	entry.sink.mu.Lock()
+	defer entry.sink.mu.Unlock()
+
+	if entry.sink.tarWriter != nil {
+		header := &tar.Header{
+			Name:    entry.name,
+			Mode:    0644,
+			Size:    int64(entry.buf.Len()),
+			ModTime: time.Unix(0, 0),
+		}
+		if err := entry.sink.tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := entry.sink.tarWriter.Write(entry.buf.Bytes())
+		return err
+	}
+
+	writer, err := entry.sink.zipWriter.Create(entry.name)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(entry.buf.Bytes())
+	return err
+}
+
+// RecordFormat selects the serialization used by a RecordSink.
+type RecordFormat int
+
+const (
+	RecordFormatJSON RecordFormat = iota
+	RecordFormatYAML
+)
+
+// Record is a single entry emitted by a RecordSink, suitable for CI
+// diffing or importing into non-Terraform systems.
+type Record struct {
+	ResourceType string            `json:"resource_type" yaml:"resource_type"`
+	Name         string            `json:"name" yaml:"name"`
+	ID           string            `json:"id,omitempty" yaml:"id,omitempty"`
+	HCL          string            `json:"hcl" yaml:"hcl"`
+	Attributes   map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+}
+
+// RecordSink renders each resource to HCL exactly as before, but instead
+// of writing a `.tf` file it captures the result into a {resource_type,
+// name, id, hcl, attributes} record and appends it to a single JSON or
+// YAML document. The `id` is recovered from the `id = ...` comment when
+// present; `attributes` is a best-effort, top-level-only parse of the
+// rendered body and does not descend into nested blocks.
+type RecordSink struct {
+	TargetFile string
+	Format     RecordFormat
+
+	mu      sync.Mutex
+	records []Record
+}
+
+// NewRecordSink creates a sink that writes targetFile once Close is
+// called.
+func NewRecordSink(targetFile string, format RecordFormat) *RecordSink {
+	return &RecordSink{TargetFile: targetFile, Format: format}
+}
+
+func (sink *RecordSink) OpenResource(resType, name string) (io.WriteCloser, error) {
+	return &recordEntry{sink: sink, resType: resType, name: name}, nil
+}
+
+func (sink *RecordSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	var data []byte
+	var err error
+	switch sink.Format {
+	case RecordFormatYAML:
+		data, err = yaml.Marshal(sink.records)
+	default:
+		data, err = json.MarshalIndent(sink.records, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sink.TargetFile, data, os.ModePerm)
+}
+
+type recordEntry struct {
+	sink    *RecordSink
+	resType string
+	name    string
+	buf     bytes.Buffer
+}
+
+func (entry *recordEntry) Write(p []byte) (int, error) { return entry.buf.Write(p) }
+
+func (entry *recordEntry) Close() error {
+	rendered := entry.buf.String()
+	record := Record{
+		ResourceType: entry.resType,
+		Name:         entry.name,
+		HCL:          rendered,
+	}
+	if id, ok := readCommentedIDFromText(rendered); ok {
+		record.ID = id
+	}
+	record.Attributes = parseTopLevelAttributes(rendered)
+
+	entry.sink.mu.Lock()
+	entry.sink.records = append(entry.sink.records, record)
+	entry.sink.mu.Unlock()
+	return nil
+}
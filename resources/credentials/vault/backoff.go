@@ -0,0 +1,37 @@
+/**
+* @license
+* Copyright 2020 Dynatrace LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package vault
+
+import (
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// newDeleteBackOff builds the exponential-backoff policy Delete uses
+// while waiting for a paired synthetic monitor's deletion to propagate
+// before retrying the credential delete. Initial interval and max
+// elapsed time are chosen to match the envelope of the fixed 40 * 2s
+// retry loop this replaces; multiplier and jitter use the library's
+// defaults.
+func newDeleteBackOff() backoff.BackOff {
+	eb := backoff.NewExponentialBackOff()
+	eb.InitialInterval = 2 * time.Second
+	eb.MaxElapsedTime = 80 * time.Second
+	return eb
+}
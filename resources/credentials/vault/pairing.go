@@ -0,0 +1,65 @@
+/**
+* @license
+* Copyright 2020 Dynatrace LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package vault
+
+// PairedMonitorMatcher recognizes whether externalVaultConfig belongs to
+// a particular external vault flavor and, if so, returns the name of the
+// synthetic HTTP monitor Dynatrace pairs with it while syncing
+// credentials - the monitor Delete must remove before the credential
+// itself can be deleted.
+type PairedMonitorMatcher func(externalVaultConfig map[string]interface{}, credentialID string) (monitorName string, ok bool)
+
+var pairedMonitorMatchers []PairedMonitorMatcher
+
+// RegisterPairedMonitorMatcher adds matcher to the set Delete consults,
+// so future external vault providers (AWS Secrets Manager, GCP Secret
+// Manager, ...) can plug in their own detection instead of requiring
+// another `else if` branch on the vault's field names.
+func RegisterPairedMonitorMatcher(matcher PairedMonitorMatcher) {
+	pairedMonitorMatchers = append(pairedMonitorMatchers, matcher)
+}
+
+func init() {
+	RegisterPairedMonitorMatcher(azureKeyVaultPairedMonitor)
+	RegisterPairedMonitorMatcher(hashiCorpVaultPairedMonitor)
+}
+
+func azureKeyVaultPairedMonitor(ext map[string]interface{}, credentialID string) (string, bool) {
+	if ext["client_secret"] != "" || ext["clientid"] != "" || ext["tenantid"] != "" {
+		return "Monitor synchronizing credentials with Azure Key Vault (" + credentialID + ")", true
+	}
+	return "", false
+}
+
+func hashiCorpVaultPairedMonitor(ext map[string]interface{}, credentialID string) (string, bool) {
+	if ext["roleid"] != "" || ext["certificate"] != "" {
+		return "Monitor synchronizing credentials with HashiCorp Vault (" + credentialID + ")", true
+	}
+	return "", false
+}
+
+// pairedMonitorName runs every registered PairedMonitorMatcher against
+// externalVaultConfig and returns the first match.
+func pairedMonitorName(externalVaultConfig map[string]interface{}, credentialID string) (string, bool) {
+	for _, matcher := range pairedMonitorMatchers {
+		if name, ok := matcher(externalVaultConfig, credentialID); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
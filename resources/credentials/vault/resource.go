@@ -20,8 +20,8 @@ package vault
 import (
 	"context"
 	"reflect"
-	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/dtcookie/dynatrace/api/config/credentials/vault"
 	"github.com/dtcookie/dynatrace/rest"
 	"github.com/dtcookie/dynatrace/terraform"
@@ -126,25 +126,23 @@ func Delete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Dia
 			}
 			if len(credentialUsageSummary) == 1 && credentialUsageSummary[0].MonitorType == vault.MonitorTypes.HTTPMonitor && credentialUsageSummary[0].Count == 1 {
 				apiService := monitors.NewService(m)
-				if monitors, err := apiService.ListHTTP(); err == nil {
+				if monitorList, err := apiService.ListHTTP(); err == nil {
 					externalVaultConfig := ext.([]interface{})[0].(map[string]interface{})
-					var compare string
-					if externalVaultConfig["client_secret"] != "" || externalVaultConfig["clientid"] != "" || externalVaultConfig["tenantid"] != "" {
-						compare = "Monitor synchronizing credentials with Azure Key Vault (" + d.Id() + ")"
-					} else if externalVaultConfig["roleid"] != "" || externalVaultConfig["certificate"] != "" {
-						compare = "Monitor synchronizing credentials with HashiCorp Vault (" + d.Id() + ")"
-					}
-					for _, monitor := range monitors.Monitors {
-						if monitor.Name == compare {
-							// log.Println("Deleting: ", monitor.Name)
-							apiService.Delete(monitor.EntityID)
-							for i := 0; i < 40; i++ {
-								if err := NewService(m).Delete(d.Id()); err == nil {
-									return diag.Diagnostics{}
+					if compare, ok := pairedMonitorName(externalVaultConfig, d.Id()); ok {
+						for _, monitor := range monitorList.Monitors {
+							if monitor.Name == compare {
+								apiService.Delete(monitor.EntityID)
+
+								var lastErr error
+								err := backoff.Retry(func() error {
+									lastErr = NewService(m).Delete(d.Id())
+									return lastErr
+								}, newDeleteBackOff())
+								if err != nil {
+									return diag.FromErr(lastErr)
 								}
-								time.Sleep(time.Second * 2)
+								return diag.Diagnostics{}
 							}
-							return diag.FromErr(err)
 						}
 					}
 				}
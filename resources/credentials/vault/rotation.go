@@ -0,0 +1,375 @@
+/**
+* @license
+* Copyright 2020 Dynatrace LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	vaultapi "github.com/hashicorp/vault/api"
+
+	"github.com/dtcookie/dynatrace/api/config/credentials/vault"
+	"github.com/dtcookie/hcl"
+	"github.com/dtcookie/opt"
+	"github.com/dynatrace-oss/terraform-provider-dynatrace/logging"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// RotationResource produces the terraform resource definition for
+// dynatrace_credentials_rotation. It is a sibling of Resource that keeps
+// an existing vault credential's secret fresh by re-issuing it from its
+// external vault on a schedule, instead of requiring a human to rotate
+// it and re-apply the dynatrace_credentials config by hand.
+//
+// It must be registered in the provider's ResourcesMap alongside Resource
+// under the type name "dynatrace_credentials_rotation"; this snapshot
+// does not include the provider registration file.
+func RotationResource() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"credential_id": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "The ID of the dynatrace_credentials resource to rotate",
+			},
+			"rotation_interval": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "How long a re-issued secret remains valid before the next rotation, expressed as a Go duration (e.g. `720h`)",
+			},
+			"rotate_now": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				ForceNew:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Arbitrary key/value pairs. Changing any value forces an immediate rotation, regardless of `rotation_interval`",
+			},
+			"vault_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The base URL of the Azure Key Vault the credential is synchronized with. Required when the credential's external vault is Azure Key Vault",
+			},
+			"secret_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The name of the secret to fetch from Azure Key Vault. Required when the credential's external vault is Azure Key Vault",
+			},
+			"vault_addr": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The address of the HashiCorp Vault server the credential is synchronized with. Required when the credential's external vault is HashiCorp Vault",
+			},
+			"secret_path": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The path of the secret to fetch from HashiCorp Vault. Required when the credential's external vault is HashiCorp Vault",
+			},
+			"last_rotated_at": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The RFC3339 timestamp of the most recent successful rotation",
+			},
+		},
+		CustomizeDiff: rotationDue,
+		CreateContext: logging.Enable(RotationCreate),
+		ReadContext:   logging.Enable(RotationRead),
+		DeleteContext: logging.Enable(RotationDelete),
+		Importer:      &schema.ResourceImporter{StateContext: schema.ImportStatePassthroughContext},
+	}
+}
+
+// rotationDue forces a new resource (and therefore a fresh RotationCreate
+// call) once rotation_interval has elapsed since last_rotated_at. This
+// mirrors the rotation_rfc3339/triggers pattern used by the upstream
+// hashicorp/terraform-provider-time time_rotating resource.
+func rotationDue(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	lastRotatedAt := d.Get("last_rotated_at").(string)
+	if lastRotatedAt == "" {
+		return nil
+	}
+
+	interval, err := time.ParseDuration(d.Get("rotation_interval").(string))
+	if err != nil {
+		return fmt.Errorf("invalid rotation_interval: %w", err)
+	}
+
+	lastRotated, err := time.Parse(time.RFC3339, lastRotatedAt)
+	if err != nil {
+		return fmt.Errorf("invalid last_rotated_at: %w", err)
+	}
+
+	if time.Now().After(lastRotated.Add(interval)) {
+		// ForceNew only accepts a key that has a diff, so mark
+		// last_rotated_at as changing first.
+		if err := d.SetNewComputed("last_rotated_at"); err != nil {
+			return err
+		}
+		return d.ForceNew("last_rotated_at")
+	}
+	return nil
+}
+
+// RotationCreate re-issues the secret of the credential referenced by
+// credential_id and pushes it to Dynatrace via vault.ServiceClient.Update,
+// then records the rotation timestamp.
+func RotationCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	credentialID := d.Get("credential_id").(string)
+
+	service := NewService(m)
+	credentials, err := service.Get(credentialID)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	rebuilt, err := reissueSecret(credentialID, credentials, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	if err := service.Update(rebuilt); err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.SetId(credentialID)
+	if err := d.Set("last_rotated_at", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return diag.FromErr(err)
+	}
+
+	return RotationRead(ctx, d, m)
+}
+
+// RotationRead confirms the referenced credential still exists.
+// Rotation itself only happens in RotationCreate, triggered by
+// rotationDue or a change to rotate_now.
+func RotationRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if _, err := NewService(m).Get(d.Id()); err != nil {
+		return diag.FromErr(err)
+	}
+	return diag.Diagnostics{}
+}
+
+// RotationDelete only removes the rotation schedule from state; the
+// underlying dynatrace_credentials resource and its current secret are
+// left untouched.
+func RotationDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	return diag.Diagnostics{}
+}
+
+// reissueSecret generates a new secret for credentials from whichever
+// external vault it is synchronized with - the same "external" block
+// Delete inspects as a map[string]interface{} with clientid/tenantid/
+// roleid/certificate/client_secret keys, not a typed struct - and
+// returns a rebuilt *vault.Credentials with the new secret applied,
+// ready for vault.ServiceClient.Update.
+func reissueSecret(credentialID string, credentials *vault.Credentials, d *schema.ResourceData) (*vault.Credentials, error) {
+	marshalled, err := credentials.MarshalHCL()
+	if err != nil {
+		return nil, err
+	}
+
+	extList, _ := marshalled["external"].([]interface{})
+	if len(extList) == 0 {
+		return nil, fmt.Errorf("credential %q is not synchronized with an external vault and cannot be rotated", credentialID)
+	}
+	ext, _ := extList[0].(map[string]interface{})
+
+	var secret string
+	switch {
+	case stringField(ext, "clientid") != "" || stringField(ext, "tenantid") != "":
+		secret, err = fetchAzureKeyVaultSecret(
+			stringField(ext, "tenantid"), stringField(ext, "clientid"), stringField(ext, "client_secret"),
+			d.Get("vault_url").(string), d.Get("secret_name").(string),
+		)
+	case stringField(ext, "roleid") != "" || stringField(ext, "certificate") != "":
+		secret, err = fetchHashiCorpVaultSecret(
+			stringField(ext, "roleid"), stringField(ext, "client_secret"), stringField(ext, "certificate"),
+			d.Get("vault_addr").(string), d.Get("secret_path").(string),
+		)
+	default:
+		return nil, fmt.Errorf("credential %q uses an external vault flavor that does not support rotation yet", credentialID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ext["client_secret"] = secret
+	extList[0] = ext
+	marshalled["external"] = extList
+
+	rebuiltData := Resource().Data(nil)
+	for k, v := range marshalled {
+		if err := rebuiltData.Set(k, v); err != nil {
+			return nil, err
+		}
+	}
+
+	rebuilt := new(vault.Credentials)
+	if err := rebuilt.UnmarshalHCL(hcl.DecoderFrom(rebuiltData)); err != nil {
+		return nil, err
+	}
+	rebuilt.ID = opt.NewString(credentialID)
+	return rebuilt, nil
+}
+
+// stringField reads key out of a schema-shaped map, tolerating a missing
+// key or map as the zero value instead of panicking.
+func stringField(m map[string]interface{}, key string) string {
+	if m == nil {
+		return ""
+	}
+	s, _ := m[key].(string)
+	return s
+}
+
+// fetchAzureKeyVaultSecret fetches the current version of secretName from
+// vaultURL, authenticating as the Azure AD application identified by
+// tenantID/clientID/clientSecret - the same fields Delete already
+// inspects to detect this external vault flavor.
+func fetchAzureKeyVaultSecret(tenantID, clientID, clientSecret, vaultURL, secretName string) (string, error) {
+	cred, err := azureClientSecretCredential(tenantID, clientID, clientSecret)
+	if err != nil {
+		return "", err
+	}
+	client, err := azsecrets.NewClient(vaultURL, cred, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.GetSecret(context.Background(), secretName, "", nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.Value == nil {
+		return "", fmt.Errorf("secret %q has no value in Azure Key Vault %q", secretName, vaultURL)
+	}
+	return *resp.Value, nil
+}
+
+// fetchHashiCorpVaultSecret fetches secretPath from vaultAddr,
+// authenticating via the AppRole identified by roleID, or via
+// certificate when cert auth is configured instead.
+func fetchHashiCorpVaultSecret(roleID, clientSecret, certificate, vaultAddr, secretPath string) (string, error) {
+	client, err := vaultapi.NewClient(&vaultapi.Config{Address: vaultAddr})
+	if err != nil {
+		return "", err
+	}
+	if err := vaultAuthenticate(client, roleID, clientSecret, certificate); err != nil {
+		return "", err
+	}
+	secret, err := client.Logical().Read(secretPath)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Data["value"] == nil {
+		return "", fmt.Errorf("secret %q has no value in HashiCorp Vault %q", secretPath, vaultAddr)
+	}
+	value, ok := secret.Data["value"].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %q in HashiCorp Vault %q is not a string value", secretPath, vaultAddr)
+	}
+	return value, nil
+}
+
+// azureClientSecretCredential wraps azidentity's client-secret flow so
+// fetchAzureKeyVaultSecret can authenticate to Key Vault the same way the
+// monitor that currently syncs from it does.
+func azureClientSecretCredential(tenantID, clientID, clientSecret string) (*azidentity.ClientSecretCredential, error) {
+	return azidentity.NewClientSecretCredential(tenantID, clientID, clientSecret, nil)
+}
+
+// vaultAuthenticate logs client into HashiCorp Vault using whichever
+// credential is carried: AppRole when roleID is set, TLS client
+// certificate otherwise.
+func vaultAuthenticate(client *vaultapi.Client, roleID, clientSecret, certificate string) error {
+	if roleID != "" {
+		secret, err := client.Logical().Write("auth/approle/login", map[string]interface{}{
+			"role_id":   roleID,
+			"secret_id": clientSecret,
+		})
+		if err != nil {
+			return err
+		}
+		client.SetToken(secret.Auth.ClientToken)
+		return nil
+	}
+
+	secret, err := client.Logical().Write("auth/cert/login", map[string]interface{}{
+		"name": certificate,
+	})
+	if err != nil {
+		return err
+	}
+	client.SetToken(secret.Auth.ClientToken)
+	return nil
+}
+
+// DataSourceCredentialUsage exposes the credential_usage_summary Dynatrace
+// reports for a vault credential, so a rotation can be gated on which
+// HTTP monitors currently reference it.
+//
+// It must be registered in the provider's DataSourcesMap under the type
+// name "dynatrace_credential_usage"; this snapshot does not include the
+// provider registration file.
+func DataSourceCredentialUsage() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"credential_id": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "The ID of the dynatrace_credentials resource to inspect",
+			},
+			"credential_usage_summary": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "The monitor types currently referencing this credential and how many of each",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"type":  {Type: schema.TypeString, Computed: true},
+						"count": {Type: schema.TypeInt, Computed: true},
+					},
+				},
+			},
+		},
+		ReadContext: logging.Enable(DataSourceCredentialUsageRead),
+	}
+}
+
+// DataSourceCredentialUsageRead fetches credential_id's usage summary.
+func DataSourceCredentialUsageRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	credentialID := d.Get("credential_id").(string)
+	credentials, err := NewService(m).Get(credentialID)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	marshalled, err := credentials.MarshalHCL()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if err := d.Set("credential_usage_summary", marshalled["credential_usage_summary"]); err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.SetId(credentialID)
+	return diag.Diagnostics{}
+}